@@ -0,0 +1,41 @@
+package db
+
+import "github.com/pkg/errors"
+
+// Backend names the DBWrapper implementation backing a store such as
+// EvmAuxStore's receipt store.
+type Backend string
+
+const (
+	BackendGoLevelDB Backend = "goleveldb"
+	BackendPebble    Backend = "pebble"
+	BackendBadger    Backend = "badger"
+)
+
+// BackendConfig is the startup configuration selecting and tuning the
+// DBWrapper backend a store opens. The zero value selects goleveldb, so
+// existing configs with no backend field set keep their current behavior.
+type BackendConfig struct {
+	Backend      Backend
+	CacheSizeMeg int
+	// SyncWrites is only honored by the Badger backend; goleveldb and
+	// Pebble are tuned via their own Set/SetSync distinction instead.
+	SyncWrites bool
+}
+
+// OpenBackend opens the DBWrapper named by cfg.Backend at dir/name. It's the
+// single switch point operators' startup flags / config fields go through
+// to pick goleveldb, Pebble, or Badger without every caller needing its own
+// copy of this branch.
+func OpenBackend(name, dir string, cfg BackendConfig) (DBWrapper, error) {
+	switch cfg.Backend {
+	case "", BackendGoLevelDB:
+		return LoadGoLevelDB(name, dir, cfg.CacheSizeMeg)
+	case BackendPebble:
+		return LoadPebbleDB(name, dir, PebbleOptions{CacheSizeMeg: cfg.CacheSizeMeg})
+	case BackendBadger:
+		return LoadBadgerDB(name, dir, BadgerOptions{CacheSizeMeg: cfg.CacheSizeMeg, SyncWrites: cfg.SyncWrites})
+	default:
+		return nil, errors.Errorf("db: unknown backend %q", cfg.Backend)
+	}
+}
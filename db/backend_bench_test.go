@@ -0,0 +1,177 @@
+package db
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+// backends lists every DBWrapper implementation so
+// BenchmarkCommitReceiptsThroughput and friends run identically across all three, making the backend choice
+// data-driven rather than a guess.
+func backends(tb testing.TB) map[string]func(dir string) (DBWrapper, error) {
+	return map[string]func(dir string) (DBWrapper, error){
+		"goleveldb": func(dir string) (DBWrapper, error) {
+			return LoadGoLevelDB("bench", dir, 64)
+		},
+		"pebble": func(dir string) (DBWrapper, error) {
+			return LoadPebbleDB("bench", dir, PebbleOptions{CacheSizeMeg: 64})
+		},
+		"badger": func(dir string) (DBWrapper, error) {
+			return LoadBadgerDB("bench", dir, BadgerOptions{CacheSizeMeg: 64})
+		},
+	}
+}
+
+const benchReceiptCount = 10_000_000
+
+// BenchmarkCommitReceiptsThroughput mirrors EvmAuxStore.CommitReceipts's
+// actual per-receipt access pattern rather than a batch of independent
+// blind writes: each receipt after the first point-gets the previous tail
+// (to rewrite its NextTxHash) before the batch sets both the rewritten tail
+// and the new one. That point-get-then-set pair, not the raw Set throughput,
+// is the part of CommitReceipts this benchmark exists to compare across
+// backends.
+func BenchmarkCommitReceiptsThroughput(b *testing.B) {
+	for name, open := range backends(b) {
+		b.Run(name, func(b *testing.B) {
+			dir, err := ioutil.TempDir("", "evmaux-bench-"+name)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			wrapper, err := open(dir)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer wrapper.Close()
+
+			var tailHash []byte
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				batch := wrapper.NewBatch()
+				for j := 0; j < 1000; j++ {
+					key := []byte(fmt.Sprintf("receipt-%d-%d", i, j))
+					if tailHash != nil {
+						_ = wrapper.Get(tailHash)
+						batch.Set(tailHash, make([]byte, 256))
+					}
+					batch.Set(key, make([]byte, 256))
+					tailHash = key
+				}
+				if err := batch.Write(); err != nil {
+					b.Fatal(err)
+				}
+				batch.Close()
+			}
+		})
+	}
+}
+
+// BenchmarkGetReceiptLatencyP99 records each Get's wall-clock latency and
+// reports the 99th percentile via b.ReportMetric, since go test -bench only
+// ever prints the mean (ns/op) and this benchmark is specifically about the
+// tail, not the average.
+func BenchmarkGetReceiptLatencyP99(b *testing.B) {
+	for name, open := range backends(b) {
+		b.Run(name, func(b *testing.B) {
+			dir, err := ioutil.TempDir("", "evmaux-bench-"+name)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			wrapper, err := open(dir)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer wrapper.Close()
+
+			key := []byte("warm-key")
+			wrapper.Set(key, make([]byte, 256))
+
+			latencies := make([]time.Duration, 0, b.N)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				start := time.Now()
+				_ = wrapper.Get(key)
+				latencies = append(latencies, time.Since(start))
+			}
+			b.StopTimer()
+
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+			idx := int(float64(len(latencies)) * 0.99)
+			if idx >= len(latencies) {
+				idx = len(latencies) - 1
+			}
+			b.ReportMetric(float64(latencies[idx].Nanoseconds()), "p99-ns/op")
+		})
+	}
+}
+
+// BenchmarkDiskUsage isn't a latency/throughput benchmark - it populates
+// benchReceiptCount receipt-sized entries and reports resulting bytes-on-disk
+// via b.ReportMetric, so `go test -bench . -benchtime 1x` produces a
+// comparable disk-usage number per backend alongside the throughput/latency
+// benchmarks above.
+func BenchmarkDiskUsage(b *testing.B) {
+	for name, open := range backends(b) {
+		b.Run(name, func(b *testing.B) {
+			dir, err := ioutil.TempDir("", "evmaux-bench-"+name)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			wrapper, err := open(dir)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer wrapper.Close()
+
+			batch := wrapper.NewBatch()
+			for i := 0; i < benchReceiptCount; i++ {
+				batch.Set([]byte(fmt.Sprintf("receipt-%d", i)), make([]byte, 256))
+				if i%10000 == 0 {
+					if err := batch.Write(); err != nil {
+						b.Fatal(err)
+					}
+					batch.Close()
+					batch = wrapper.NewBatch()
+				}
+			}
+			_ = batch.Write()
+			batch.Close()
+
+			size, err := dirSize(dir)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportMetric(float64(size), "bytes-on-disk")
+		})
+	}
+}
+
+func dirSize(dir string) (int64, error) {
+	var total int64
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			sub, err := dirSize(dir + "/" + e.Name())
+			if err != nil {
+				return 0, err
+			}
+			total += sub
+			continue
+		}
+		total += e.Size()
+	}
+	return total, nil
+}
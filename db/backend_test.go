@@ -0,0 +1,33 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenBackendDefaultsToGoLevelDB(t *testing.T) {
+	dir := t.TempDir()
+	wrapper, err := OpenBackend("test", dir, BackendConfig{})
+	require.NoError(t, err)
+	defer wrapper.Close()
+
+	_, ok := wrapper.(*GoLevelDB)
+	require.True(t, ok, "empty Backend should select goleveldb")
+}
+
+func TestOpenBackendExplicitGoLevelDB(t *testing.T) {
+	dir := t.TempDir()
+	wrapper, err := OpenBackend("test", dir, BackendConfig{Backend: BackendGoLevelDB})
+	require.NoError(t, err)
+	defer wrapper.Close()
+
+	_, ok := wrapper.(*GoLevelDB)
+	require.True(t, ok)
+}
+
+func TestOpenBackendUnknownBackend(t *testing.T) {
+	dir := t.TempDir()
+	_, err := OpenBackend("test", dir, BackendConfig{Backend: Backend("unknown")})
+	require.Error(t, err)
+}
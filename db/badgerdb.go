@@ -0,0 +1,287 @@
+package db
+
+import (
+	"strconv"
+
+	badger "github.com/dgraph-io/badger/v2"
+	dbm "github.com/tendermint/tendermint/libs/db"
+)
+
+// BadgerOptions configures a BadgerDB-backed DBWrapper.
+type BadgerOptions struct {
+	CacheSizeMeg int
+	// SyncWrites mirrors badger.Options.SyncWrites; most chains leave this
+	// false and rely on SetSync/WriteSync for the writes that actually need
+	// the fsync.
+	SyncWrites bool
+}
+
+// BadgerDB is a DBWrapper backed by github.com/dgraph-io/badger, offered as
+// a second alternative to goleveldb alongside PebbleDB - Badger's LSM design
+// with separate value log tends to do well on the large-value, high-churn
+// access pattern receipts produce.
+type BadgerDB struct {
+	db *badger.DB
+}
+
+var _ DBWrapper = &BadgerDB{}
+
+// LoadBadgerDB opens (creating if necessary) a Badger database at dir/name.
+func LoadBadgerDB(name, dir string, opts BadgerOptions) (*BadgerDB, error) {
+	path := dir + "/" + name + ".db"
+	badgerOpts := badger.DefaultOptions(path).
+		WithSyncWrites(opts.SyncWrites).
+		WithBlockCacheSize(int64(opts.CacheSizeMeg) * 1024 * 1024)
+
+	bdb, err := badger.Open(badgerOpts)
+	if err != nil {
+		return nil, err
+	}
+	return &BadgerDB{db: bdb}, nil
+}
+
+func (b *BadgerDB) Get(key []byte) []byte {
+	var out []byte
+	err := b.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			out = append([]byte(nil), val...)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+func (b *BadgerDB) Has(key []byte) bool {
+	return b.Get(key) != nil
+}
+
+func (b *BadgerDB) Set(key, value []byte) {
+	_ = b.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(key, value)
+	})
+}
+
+func (b *BadgerDB) SetSync(key, value []byte) {
+	b.Set(key, value)
+	_ = b.db.Sync()
+}
+
+func (b *BadgerDB) Delete(key []byte) {
+	_ = b.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(key)
+	})
+}
+
+func (b *BadgerDB) DeleteSync(key []byte) {
+	b.Delete(key)
+	_ = b.db.Sync()
+}
+
+func (b *BadgerDB) Iterator(start, end []byte) dbm.Iterator {
+	txn := b.db.NewTransaction(false)
+	opts := badger.DefaultIteratorOptions
+	iter := txn.NewIterator(opts)
+	iter.Seek(start)
+	return &badgerIterator{txn: txn, iter: iter, end: end}
+}
+
+func (b *BadgerDB) ReverseIterator(start, end []byte) dbm.Iterator {
+	txn := b.db.NewTransaction(false)
+	opts := badger.DefaultIteratorOptions
+	opts.Reverse = true
+	iter := txn.NewIterator(opts)
+	iter.Seek(end)
+	return &badgerIterator{txn: txn, iter: iter, end: start, reverse: true}
+}
+
+func (b *BadgerDB) Close() {
+	_ = b.db.Close()
+}
+
+func (b *BadgerDB) Print() {}
+
+func (b *BadgerDB) Stats() map[string]string {
+	lsm, vlog := b.db.Size()
+	return map[string]string{
+		"badger.lsm.bytes":  strconv.FormatInt(lsm, 10),
+		"badger.vlog.bytes": strconv.FormatInt(vlog, 10),
+	}
+}
+
+func (b *BadgerDB) NewBatch() dbm.Batch {
+	return &badgerBatch{db: b.db, wb: b.db.NewWriteBatch()}
+}
+
+// Compact runs Badger's value log GC repeatedly until it reports nothing
+// left to reclaim, which is the closest Badger analogue to goleveldb's
+// range compaction.
+func (b *BadgerDB) Compact() error {
+	for {
+		if err := b.db.RunValueLogGC(0.5); err != nil {
+			if err == badger.ErrNoRewrite {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// GetSnapshot returns a read-only view pinned to the current Badger
+// transaction, used by Migrate.
+func (b *BadgerDB) GetSnapshot() Snapshot {
+	return &BadgerSnapshot{txn: b.db.NewTransaction(false)}
+}
+
+// BadgerSnapshot wraps a read-only badger.Txn to satisfy the Snapshot
+// interface.
+type BadgerSnapshot struct {
+	txn *badger.Txn
+}
+
+func (s *BadgerSnapshot) Get(key []byte) []byte {
+	item, err := s.txn.Get(key)
+	if err != nil {
+		return nil
+	}
+	var out []byte
+	_ = item.Value(func(val []byte) error {
+		out = append([]byte(nil), val...)
+		return nil
+	})
+	return out
+}
+
+func (s *BadgerSnapshot) Release() {
+	s.txn.Discard()
+}
+
+// Iterator gives Migrate (and anything else that needs to walk this
+// snapshot's keys rather than just point-get them) a range iterator pinned
+// to the same read-only transaction, instead of the live db.
+func (s *BadgerSnapshot) Iterator(start, end []byte) dbm.Iterator {
+	opts := badger.DefaultIteratorOptions
+	iter := s.txn.NewIterator(opts)
+	iter.Seek(start)
+	return &badgerSnapshotIterator{iter: iter, end: end}
+}
+
+// badgerSnapshotIterator is like badgerIterator but doesn't own (and so
+// must not discard) the transaction it was created from, since
+// BadgerSnapshot.Release already discards it.
+type badgerSnapshotIterator struct {
+	iter *badger.Iterator
+	end  []byte
+}
+
+func (it *badgerSnapshotIterator) Domain() (start, end []byte) { return nil, nil }
+
+func (it *badgerSnapshotIterator) Valid() bool {
+	if !it.iter.Valid() {
+		return false
+	}
+	if len(it.end) == 0 {
+		return true
+	}
+	return string(it.iter.Item().Key()) < string(it.end)
+}
+
+func (it *badgerSnapshotIterator) Next() {
+	it.iter.Next()
+}
+
+func (it *badgerSnapshotIterator) Key() []byte {
+	return append([]byte(nil), it.iter.Item().Key()...)
+}
+
+func (it *badgerSnapshotIterator) Value() []byte {
+	var out []byte
+	_ = it.iter.Item().Value(func(val []byte) error {
+		out = append([]byte(nil), val...)
+		return nil
+	})
+	return out
+}
+
+func (it *badgerSnapshotIterator) Close() {
+	it.iter.Close()
+}
+
+type badgerIterator struct {
+	txn     *badger.Txn
+	iter    *badger.Iterator
+	end     []byte
+	reverse bool
+}
+
+func (it *badgerIterator) Domain() (start, end []byte) { return nil, nil }
+
+func (it *badgerIterator) Valid() bool {
+	if !it.iter.Valid() {
+		return false
+	}
+	if len(it.end) == 0 {
+		return true
+	}
+	key := it.iter.Item().Key()
+	if it.reverse {
+		return string(key) >= string(it.end)
+	}
+	return string(key) < string(it.end)
+}
+
+func (it *badgerIterator) Next() {
+	it.iter.Next()
+}
+
+func (it *badgerIterator) Key() []byte {
+	return append([]byte(nil), it.iter.Item().Key()...)
+}
+
+func (it *badgerIterator) Value() []byte {
+	var out []byte
+	_ = it.iter.Item().Value(func(val []byte) error {
+		out = append([]byte(nil), val...)
+		return nil
+	})
+	return out
+}
+
+func (it *badgerIterator) Close() {
+	it.iter.Close()
+	it.txn.Discard()
+}
+
+type badgerBatch struct {
+	db *badger.DB
+	wb *badger.WriteBatch
+}
+
+func (b *badgerBatch) Set(key, value []byte) {
+	_ = b.wb.Set(key, value)
+}
+
+func (b *badgerBatch) Delete(key []byte) {
+	_ = b.wb.Delete(key)
+}
+
+func (b *badgerBatch) Write() error {
+	return b.wb.Flush()
+}
+
+func (b *badgerBatch) WriteSync() error {
+	if err := b.wb.Flush(); err != nil {
+		return err
+	}
+	return b.db.Sync()
+}
+
+func (b *badgerBatch) Close() {
+	b.wb.Cancel()
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/util"
 	dbm "github.com/tendermint/tendermint/libs/db"
@@ -26,6 +27,39 @@ func (g *GoLevelDB) GetSnapshot() Snapshot {
 	}
 }
 
+// Iterator gives Migrate (and anything else that needs to walk this
+// snapshot's keys rather than just point-get them) a range iterator over
+// the point-in-time view, instead of the live db.
+func (s *GoLevelDBSnapshot) Iterator(start, end []byte) dbm.Iterator {
+	iter := s.Snapshot.NewIterator(&util.Range{Start: start, Limit: end}, nil)
+	iter.First()
+	return &goLevelDBSnapshotIterator{iter: iter}
+}
+
+type goLevelDBSnapshotIterator struct {
+	iter iterator.Iterator
+}
+
+func (it *goLevelDBSnapshotIterator) Domain() (start, end []byte) { return nil, nil }
+
+func (it *goLevelDBSnapshotIterator) Valid() bool { return it.iter.Valid() }
+
+func (it *goLevelDBSnapshotIterator) Next() { it.iter.Next() }
+
+func (it *goLevelDBSnapshotIterator) Key() []byte {
+	out := make([]byte, len(it.iter.Key()))
+	copy(out, it.iter.Key())
+	return out
+}
+
+func (it *goLevelDBSnapshotIterator) Value() []byte {
+	out := make([]byte, len(it.iter.Value()))
+	copy(out, it.iter.Value())
+	return out
+}
+
+func (it *goLevelDBSnapshotIterator) Close() { it.iter.Release() }
+
 func LoadGoLevelDB(name, dir string, cacheSizeMeg int) (*GoLevelDB, error) {
 
 	o := &opt.Options{
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"github.com/pkg/errors"
+	dbm "github.com/tendermint/tendermint/libs/db"
+)
+
+// snapshotIterator is satisfied by a Snapshot that also supports range
+// iteration over the point-in-time view it captured. Not every Snapshot
+// necessarily does (the core interface only guarantees Get/Release), so
+// Migrate feature-detects it the same way the prune worker feature-detects
+// Compact() on a DBWrapper.
+type snapshotIterator interface {
+	Iterator(start, end []byte) dbm.Iterator
+}
+
+// Migrate copies every key in src to dst under a single checkpointed
+// snapshot of src, so operators can move an existing receipt store (e.g.
+// from GoLevelDB to Pebble or Badger) without downtime beyond the single
+// restart needed to point the node at dst afterwards.
+//
+// It iterates the snapshot itself rather than the live src - iterating src
+// and only reading values back through the snapshot would miss any key
+// that's deleted from src after the iterator passes its position but before
+// the snapshot is released, silently breaking the "copies all keys under a
+// checkpointed snapshot" guarantee this is meant to provide while src keeps
+// taking writes.
+//
+// dst is expected to be empty; Migrate does not delete any pre-existing
+// keys in dst.
+func Migrate(src, dst DBWrapper) error {
+	snapshot := src.GetSnapshot()
+	defer snapshot.Release()
+
+	iterable, ok := snapshot.(snapshotIterator)
+	if !ok {
+		return errors.New("migrate: src snapshot does not support iteration")
+	}
+	iter := iterable.Iterator(nil, nil)
+	defer iter.Close()
+
+	const flushEvery = 10000
+	written := 0
+	batch := dst.NewBatch()
+	for ; iter.Valid(); iter.Next() {
+		batch.Set(iter.Key(), iter.Value())
+		written++
+		if written%flushEvery == 0 {
+			if err := batch.Write(); err != nil {
+				batch.Close()
+				return err
+			}
+			batch.Close()
+			batch = dst.NewBatch()
+		}
+	}
+	err := batch.Write()
+	batch.Close()
+	return err
+}
@@ -0,0 +1,20 @@
+package evmaux
+
+import "github.com/loomnetwork/loomchain/db"
+
+// Config is the startup configuration for the DBWrapper backing an
+// EvmAuxStore's receipt store. It's the single config field / CLI flag an
+// operator needs to move off goleveldb onto Pebble or Badger - see
+// db.BackendConfig for the backend-specific options.
+type Config struct {
+	Backend     db.BackendConfig
+	MaxReceipts uint64
+}
+
+// OpenDB opens the DBWrapper selected by cfg.Backend at dir/name, for use as
+// the db an EvmAuxStore is constructed against. It's the evmaux-side call
+// site for db.OpenBackend: startup code threads its backend config field
+// through here instead of hard-coding a LoadGoLevelDB call.
+func OpenDB(name, dir string, cfg Config) (db.DBWrapper, error) {
+	return db.OpenBackend(name, dir, cfg.Backend)
+}
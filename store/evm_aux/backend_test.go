@@ -0,0 +1,20 @@
+package evmaux
+
+import (
+	"testing"
+
+	"github.com/loomnetwork/loomchain/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenDBSelectsConfiguredBackend(t *testing.T) {
+	backends := []db.Backend{db.BackendGoLevelDB, db.BackendPebble, db.BackendBadger}
+	for _, backend := range backends {
+		t.Run(string(backend), func(t *testing.T) {
+			dir := t.TempDir()
+			wrapper, err := OpenDB("test", dir, Config{Backend: db.BackendConfig{Backend: backend}})
+			require.NoError(t, err)
+			defer wrapper.Close()
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package evmaux
+
+import (
+	"encoding/binary"
+)
+
+// bloomMipMapLevels are the bucket sizes (in blocks) of the MIPmap, from
+// finest to coarsest. Each level aggregates the per-height blooms that
+// CommitReceipts already stores (via setBloomFilter) into one OR'd bloom per
+// bucket, so a range query can skip whole buckets that can't possibly match
+// instead of testing every block's bloom individually.
+var bloomMipMapLevels = []uint64{1000, 50000, 100000, 500000, 1000000}
+
+const bloomMipMapKeyPrefix = "bloommipmap"
+
+// bloomMipMapKey derives the storage key for the aggregate bloom covering
+// bucket `height/level` at the given level.
+func bloomMipMapKey(level, height uint64) []byte {
+	bucket := height / level
+	key := make([]byte, 0, len(bloomMipMapKeyPrefix)+16)
+	key = append(key, bloomMipMapKeyPrefix...)
+	levelB := make([]byte, 8)
+	binary.BigEndian.PutUint64(levelB, level)
+	bucketB := make([]byte, 8)
+	binary.BigEndian.PutUint64(bucketB, bucket)
+	key = append(key, levelB...)
+	key = append(key, bucketB...)
+	return key
+}
+
+// updateBloomMipMap ORs a newly committed block's bloom into every MIPmap
+// level bucket it falls under. It's called from CommitReceipts right after
+// the per-height bloom is written.
+func (s *EvmAuxStore) updateBloomMipMap(filter []byte, height uint64) {
+	for _, level := range bloomMipMapLevels {
+		key := bloomMipMapKey(level, height)
+		existing := s.db.Get(key)
+		s.batch.Set(key, orBloomInto(existing, filter))
+	}
+}
+
+// RebuildBloomMipMap scans the per-height blooms already stored by
+// setBloomFilter and populates the MIPmap level buckets from scratch. It's
+// meant to be run once when an existing chain adopts the index, so chains
+// don't need to resync to get range log-filter queries sped up.
+func (s *EvmAuxStore) RebuildBloomMipMap(fromHeight, toHeight uint64) error {
+	mu := s.lockCommit()
+	mu.Lock()
+	defer mu.Unlock()
+
+	s.Rollback()
+	buckets := map[string][]byte{}
+	for height := fromHeight; height <= toHeight; height++ {
+		filter := s.getBloomFilter(height)
+		if len(filter) == 0 {
+			// No bloom was ever recorded for this height (a hole); skip it,
+			// it contributes nothing to any bucket.
+			continue
+		}
+		for _, level := range bloomMipMapLevels {
+			key := string(bloomMipMapKey(level, height))
+			buckets[key] = orBloomInto(buckets[key], filter)
+		}
+	}
+	for key, filter := range buckets {
+		s.batch.Set([]byte(key), filter)
+	}
+	s.Commit()
+	return nil
+}
+
+// MatchingBlocks returns every block height in [fromBlock, toBlock] whose
+// bloom may contain a log matching the given addresses/topics. It walks the
+// MIPmap from the coarsest level down, skipping whole bucket ranges that
+// can't match, and only falls back to the per-height bloom once the finest
+// level bucket is positive. False positives are possible (the caller is
+// expected to re-check candidate blocks against the full log data); false
+// negatives are not.
+func (s *EvmAuxStore) MatchingBlocks(fromBlock, toBlock uint64, addresses [][]byte, topics [][][]byte) []uint64 {
+	if toBlock < fromBlock {
+		return nil
+	}
+	query := buildQueryBloom(addresses, topics)
+
+	var matches []uint64
+	finest := bloomMipMapLevels[0]
+	bucketStart := fromBlock - fromBlock%finest
+	for bucketStart <= toBlock {
+		rejectedLevel, ok := mipMapRangeMayMatch(len(bloomMipMapLevels)-1, bucketStart, query, func(level, height uint64) []byte {
+			return s.db.Get(bloomMipMapKey(level, height))
+		})
+		if !ok {
+			// Skip past the whole range covered by whichever level's bucket
+			// came back bloom-negative, not just the one finest-level
+			// bucket at bucketStart - that's the entire point of walking
+			// coarse-to-fine instead of scanning every finest bucket.
+			level := bloomMipMapLevels[rejectedLevel]
+			bucketStart = bucketStart - bucketStart%level + level
+			continue
+		}
+
+		bucketEnd := bucketStart + finest - 1
+		lo := bucketStart
+		if lo < fromBlock {
+			lo = fromBlock
+		}
+		hi := bucketEnd
+		if hi > toBlock {
+			hi = toBlock
+		}
+		for height := lo; height <= hi; height++ {
+			filter := s.getBloomFilter(height)
+			if len(filter) == 0 {
+				continue
+			}
+			if bloomContainsAll(filter, query) {
+				matches = append(matches, height)
+			}
+		}
+		bucketStart += finest
+	}
+	return matches
+}
+
+// mipMapRangeMayMatch checks, from the coarsest level (levelIdx) down to the
+// finest, whether the bucket containing `height` at each level could
+// possibly contain a match. It returns the index of the first (coarsest)
+// level whose bucket came back bloom-negative and ok=false, so the caller
+// can skip that level's entire bucket range in one jump rather than
+// stepping through it one finest-level bucket at a time. Kept pure (the
+// bucket lookup is injected) so it can be unit tested without a store.
+func mipMapRangeMayMatch(levelIdx int, height uint64, query []byte, lookup func(level, height uint64) []byte) (rejectedLevel int, ok bool) {
+	for i := levelIdx; i >= 0; i-- {
+		level := bloomMipMapLevels[i]
+		aggregate := lookup(level, height)
+		if len(aggregate) == 0 {
+			// Never populated (e.g. a hole, or chain shorter than this
+			// level's bucket) - treat as no match rather than erroring.
+			return i, false
+		}
+		if !bloomContainsAll(aggregate, query) {
+			return i, false
+		}
+	}
+	return 0, true
+}
@@ -0,0 +1,100 @@
+package evmaux
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBloomMipMapKeyDeterministic(t *testing.T) {
+	// The last block of a bucket and the first block of the next bucket
+	// must map to different keys, while all blocks within a bucket must
+	// map to the same key.
+	level := bloomMipMapLevels[0]
+	lastOfBucket := level - 1
+	firstOfNextBucket := level
+
+	require.Equal(t, bloomMipMapKey(level, 0), bloomMipMapKey(level, lastOfBucket))
+	require.NotEqual(t, bloomMipMapKey(level, lastOfBucket), bloomMipMapKey(level, firstOfNextBucket))
+}
+
+func TestBloomContainsAllHandlesHoles(t *testing.T) {
+	// A bucket that was never populated (e.g. because setBloomFilter was
+	// never called for any height in its range) must be treated as
+	// non-matching rather than causing a panic or false positive.
+	var aggregate []byte
+	query := buildQueryBloom([][]byte{[]byte("some-address")}, nil)
+	require.False(t, bloomContainsAll(aggregate, query))
+}
+
+func TestBloomContainsAllSupersetInvariant(t *testing.T) {
+	// OR-ing two blooms together must never cause a block's own bloom to
+	// stop being contained in the aggregate.
+	a := buildQueryBloom([][]byte{[]byte("addr-a")}, nil)
+	b := buildQueryBloom([][]byte{[]byte("addr-b")}, nil)
+	aggregate := orBloomInto(append([]byte(nil), a...), b)
+
+	require.True(t, bloomContainsAll(aggregate, a))
+	require.True(t, bloomContainsAll(aggregate, b))
+}
+
+func TestMipMapRangeMayMatchReturnsCoarsestRejectingLevel(t *testing.T) {
+	// A bucket that's bloom-negative at the coarsest level must be reported
+	// as rejected at that level's index, not the finest - that's what lets
+	// MatchingBlocks skip the whole coarse bucket's range in one jump
+	// instead of re-checking the same negative coarse bucket once per
+	// finest-level bucket inside it.
+	query := buildQueryBloom([][]byte{[]byte("needle")}, nil)
+	coarsestIdx := len(bloomMipMapLevels) - 1
+
+	lookup := func(level, height uint64) []byte {
+		if level == bloomMipMapLevels[coarsestIdx] {
+			return nil // never populated -> bloom-negative
+		}
+		return buildQueryBloom([][]byte{[]byte("needle")}, nil)
+	}
+
+	rejected, ok := mipMapRangeMayMatch(coarsestIdx, 0, query, lookup)
+
+	require.False(t, ok)
+	require.Equal(t, coarsestIdx, rejected)
+}
+
+func TestMipMapRangeMayMatchReturnsFinestRejectingLevelWhenCoarseMatches(t *testing.T) {
+	// If every coarser level matches but the finest doesn't, the rejection
+	// must be reported at the finest level, not the coarsest - skipping too
+	// far would drop candidate blocks outside this finest bucket that the
+	// coarser levels still admit.
+	query := buildQueryBloom([][]byte{[]byte("needle")}, nil)
+
+	lookup := func(level, height uint64) []byte {
+		if level == bloomMipMapLevels[0] {
+			return nil
+		}
+		return query
+	}
+
+	rejected, ok := mipMapRangeMayMatch(len(bloomMipMapLevels)-1, 0, query, lookup)
+
+	require.False(t, ok)
+	require.Equal(t, 0, rejected)
+}
+
+func TestMipMapRangeMayMatchOkWhenEveryLevelMatches(t *testing.T) {
+	query := buildQueryBloom([][]byte{[]byte("needle")}, nil)
+	lookup := func(level, height uint64) []byte { return query }
+
+	_, ok := mipMapRangeMayMatch(len(bloomMipMapLevels)-1, 0, query, lookup)
+
+	require.True(t, ok)
+}
+
+func TestBloomMipMapKeySpansMultipleTopLevelBuckets(t *testing.T) {
+	// A range spanning multiple top-level buckets must map each bucket to
+	// its own key, so their aggregates stay independent rather than
+	// bleeding into each other.
+	topLevel := bloomMipMapLevels[len(bloomMipMapLevels)-1]
+	keyBucket0 := bloomMipMapKey(topLevel, 0)
+	keyBucket1 := bloomMipMapKey(topLevel, topLevel)
+	require.NotEqual(t, keyBucket0, keyBucket1)
+}
@@ -0,0 +1,80 @@
+package evmaux
+
+import (
+	"golang.org/x/crypto/sha3"
+)
+
+// Shared helpers for building and testing the byte-level Ethereum-style
+// blooms used by both the MIPmap index (bloom_mipmap.go) and the bloombits
+// section index (bloombits.go).
+
+const (
+	bloomByteLength = 256
+	bloomBitLength  = bloomByteLength * 8
+)
+
+// bloomContainsAll reports whether every bit set in query is also set in
+// aggregate. Since an aggregate bloom is built by OR-ing together one or
+// more per-block blooms, this containment check can never produce a false
+// negative: if aggregate does not contain query, none of the blocks that
+// were OR'd into it can possibly match. A true result only means the range
+// must be checked further (it may still be a false positive).
+func bloomContainsAll(aggregate, query []byte) bool {
+	for i := 0; i < len(query); i++ {
+		var a byte
+		if i < len(aggregate) {
+			a = aggregate[i]
+		}
+		if a&query[i] != query[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// orBloomInto ORs src into dst in place, growing dst to bloomByteLength if
+// necessary. dst may be nil, in which case a fresh copy of src is returned.
+func orBloomInto(dst, src []byte) []byte {
+	if len(dst) == 0 {
+		out := make([]byte, bloomByteLength)
+		copy(out, src)
+		return out
+	}
+	for i := 0; i < len(dst) && i < len(src); i++ {
+		dst[i] |= src[i]
+	}
+	return dst
+}
+
+// setBloomBit sets the 3 bits in filter (a bloomByteLength-byte slice) at
+// the positions go-ethereum's bloom9 derives from the low 11 bits of 3
+// non-overlapping 2-byte windows of a Keccak256 hash.
+func setBloomBit(filter []byte, hash []byte) {
+	for i := 0; i < 3; i++ {
+		bit := (uint(hash[2*i])<<8 | uint(hash[2*i+1])) & (bloomBitLength - 1)
+		filter[bloomByteLength-1-bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// buildQueryBloom constructs the bloom filter that an eth_getLogs style
+// query (addresses ORed with topics) would have set in any block it
+// matches, so it can be tested for containment against the aggregate blooms
+// kept by the MIPmap and bloombits indices.
+func buildQueryBloom(addresses [][]byte, topics [][][]byte) []byte {
+	filter := make([]byte, bloomByteLength)
+	for _, addr := range addresses {
+		setBloomBit(filter, keccak256(addr))
+	}
+	for _, topicGroup := range topics {
+		for _, topic := range topicGroup {
+			setBloomBit(filter, keccak256(topic))
+		}
+	}
+	return filter
+}
+
+func keccak256(data []byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	h.Write(data)
+	return h.Sum(nil)
+}
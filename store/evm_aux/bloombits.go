@@ -0,0 +1,418 @@
+package evmaux
+
+import (
+	"encoding/binary"
+	"math/big"
+	"sync"
+
+	"github.com/loomnetwork/go-loom/plugin/types"
+	"github.com/loomnetwork/loomchain/log"
+	"github.com/pkg/errors"
+)
+
+// bloomSectionSize is the number of blocks grouped into one bloombits
+// section, mirroring go-ethereum's default.
+const bloomSectionSize = uint64(4096)
+
+// bloomBitsPerFilter is the number of bits in a single block bloom (256
+// bytes * 8).
+const bloomBitsPerFilter = bloomBitLength
+
+const (
+	bloomBitsKeyPrefix        = "bloombits"
+	bloomBitsCompletedSection = "bloombitshead"
+)
+
+var errIncompleteSection = errors.New("evmaux: bloombits section is incomplete")
+
+// sectionBuilders tracks the one background bloomSectionBuilder per
+// EvmAuxStore instance. EvmAuxStore itself doesn't carry a field for it
+// since callers construct it without knowledge of the bloombits feature;
+// the builder is instead started lazily the first time a store commits
+// receipts.
+var (
+	sectionBuildersMu sync.Mutex
+	sectionBuilders   = map[*EvmAuxStore]*bloomSectionBuilder{}
+)
+
+// notifyBloomSectionHeight lazily starts this store's background section
+// builder and notifies it of the latest committed height. It's cheap and
+// non-blocking, safe to call from the CommitReceipts hot path.
+func (s *EvmAuxStore) notifyBloomSectionHeight(height uint64) {
+	sectionBuildersMu.Lock()
+	b, ok := sectionBuilders[s]
+	if !ok {
+		b = newBloomSectionBuilder(s)
+		sectionBuilders[s] = b
+	}
+	sectionBuildersMu.Unlock()
+	b.notifyHeight(height)
+}
+
+// StopBloomSectionBuilder stops the background section builder associated
+// with this store, if one was started. Safe to call even if none was ever
+// started.
+func (s *EvmAuxStore) StopBloomSectionBuilder() {
+	sectionBuildersMu.Lock()
+	b, ok := sectionBuilders[s]
+	if ok {
+		delete(sectionBuilders, s)
+	}
+	sectionBuildersMu.Unlock()
+	if ok {
+		b.stop()
+	}
+}
+
+// bloomBitsKey is the storage key for the rotated, compressed bit-vector of
+// bit position `bit` over section `section`.
+func bloomBitsKey(bit uint, section uint64) []byte {
+	key := make([]byte, 0, len(bloomBitsKeyPrefix)+10)
+	key = append(key, bloomBitsKeyPrefix...)
+	bitB := make([]byte, 2)
+	binary.BigEndian.PutUint16(bitB, uint16(bit))
+	sectionB := make([]byte, 8)
+	binary.BigEndian.PutUint64(sectionB, section)
+	key = append(key, bitB...)
+	key = append(key, sectionB...)
+	return key
+}
+
+// bloomSectionBuilder runs in the background, lagging the chain head by one
+// full section, so a section is only ever written once every block in it
+// has a bloom already committed. This keeps CommitReceipts off the critical
+// path for building the (more expensive) rotated bit-vectors.
+type bloomSectionBuilder struct {
+	store   *EvmAuxStore
+	trigger chan uint64
+	quit    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func newBloomSectionBuilder(store *EvmAuxStore) *bloomSectionBuilder {
+	b := &bloomSectionBuilder{
+		store:   store,
+		trigger: make(chan uint64, 1),
+		quit:    make(chan struct{}),
+	}
+	b.wg.Add(1)
+	go b.run()
+	return b
+}
+
+func (b *bloomSectionBuilder) run() {
+	defer b.wg.Done()
+	for {
+		select {
+		case height := <-b.trigger:
+			b.buildCompletedSections(height)
+		case <-b.quit:
+			return
+		}
+	}
+}
+
+// notifyHeight is called (non-blocking) every time a block's bloom is
+// committed. It never blocks CommitReceipts: if the builder is still
+// working through a previous notification the new height is simply dropped,
+// since the builder will pick up the lag next time it checks.
+func (b *bloomSectionBuilder) notifyHeight(height uint64) {
+	select {
+	case b.trigger <- height:
+	default:
+	}
+}
+
+func (b *bloomSectionBuilder) stop() {
+	close(b.quit)
+	b.wg.Wait()
+}
+
+// buildCompletedSections writes out every section that's fully covered by
+// blocks up to `height`, i.e. every section except the one the head is
+// currently in (the "lag by one section" rule), and any later sections that
+// are already complete but weren't built yet (e.g. after catching up from a
+// backlog).
+func (b *bloomSectionBuilder) buildCompletedSections(height uint64) {
+	if height < bloomSectionSize {
+		return
+	}
+	lastCompleteSection := height/bloomSectionSize - 1
+	nextSection := b.store.nextBloomBitsSection()
+	for section := nextSection; section <= lastCompleteSection; section++ {
+		if err := b.store.CommitBloomSection(section); err != nil {
+			log.Error("bloombits: failed to build section", "section", section, "err", err)
+			return
+		}
+	}
+}
+
+// nextBloomBitsSection returns the lowest section number that hasn't been
+// committed yet, used both by the background builder and by crash recovery.
+func (s *EvmAuxStore) nextBloomBitsSection() uint64 {
+	b := s.db.Get([]byte(bloomBitsCompletedSection))
+	if len(b) == 0 {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b) + 1
+}
+
+// CommitBloomSection reads the per-block blooms already kept for `section`
+// (blocks [section*bloomSectionSize, (section+1)*bloomSectionSize)) and
+// writes the 2048 rotated, compressed bit-vectors atomically. A block whose
+// bloom hasn't been recorded is treated as a hole (see buildSectionVectors),
+// not an error, so the caller (the background builder, or recovery) only
+// calls this for sections whose blocks are all already committed.
+func (s *EvmAuxStore) CommitBloomSection(section uint64) error {
+	mu := s.lockCommit()
+	mu.Lock()
+	defer mu.Unlock()
+
+	s.Rollback()
+	from := section * bloomSectionSize
+	to := from + bloomSectionSize
+
+	vectors, err := buildSectionVectors(from, to, s.getBloomFilter)
+	if err != nil {
+		return err
+	}
+
+	for bit, vector := range vectors {
+		s.batch.Set(bloomBitsKey(uint(bit), section), compressBitVector(vector))
+	}
+	sectionB := make([]byte, 8)
+	binary.LittleEndian.PutUint64(sectionB, section)
+	s.batch.Set([]byte(bloomBitsCompletedSection), sectionB)
+
+	s.Commit()
+	return nil
+}
+
+// buildSectionVectors rotates the per-height blooms over [from, to) - as
+// returned by bloomLookup - into bloomBitsPerFilter bit-vectors, one per
+// bloom bit position, each bloomSectionSize bits long (one bit per block). A
+// height with no recorded bloom (e.g. a zero-receipt block) is a hole, the
+// same as bloom_mipmap.go's RebuildBloomMipMap treats it: it simply
+// contributes no set bits rather than failing the whole section, since
+// "no receipts" is a completely ordinary outcome, not data loss. Kept pure
+// (the bloom lookup is injected) so CommitBloomSection's actual rotation
+// logic can be unit tested without a live store.
+func buildSectionVectors(from, to uint64, bloomLookup func(height uint64) []byte) ([][]byte, error) {
+	vectors := make([][]byte, bloomBitsPerFilter)
+	for i := range vectors {
+		vectors[i] = make([]byte, bloomSectionSize/8)
+	}
+
+	for height := from; height < to; height++ {
+		filter := bloomLookup(height)
+		if len(filter) == 0 {
+			continue
+		}
+		offset := height - from
+		for bit := uint(0); bit < bloomBitsPerFilter; bit++ {
+			byteIdx := bloomByteLength - 1 - int(bit)/8
+			bitIdx := bit % 8
+			if filter[byteIdx]&(1<<bitIdx) == 0 {
+				continue
+			}
+			vectors[bit][offset/8] |= 1 << (offset % 8)
+		}
+	}
+	return vectors, nil
+}
+
+// RecoverIncompleteBloomSections rebuilds every section from
+// nextBloomBitsSection() up to the section completed at chainHeight. It's
+// meant to be run once at startup, since the rotated vectors for a section
+// and the "last completed section" pointer are written atomically but a
+// crash could still leave the builder's in-memory lag state stale.
+func (s *EvmAuxStore) RecoverIncompleteBloomSections(chainHeight uint64) error {
+	if chainHeight < bloomSectionSize {
+		return nil
+	}
+	lastCompleteSection := chainHeight/bloomSectionSize - 1
+	for section := s.nextBloomBitsSection(); section <= lastCompleteSection; section++ {
+		if err := s.CommitBloomSection(section); err != nil {
+			return errors.Wrapf(err, "recovering bloombits section %d", section)
+		}
+	}
+	return nil
+}
+
+// MatchSections ANDs together the rotated bit-vectors for every bit
+// position required by the queried addresses/topics, across every section
+// overlapping [from, to]. The result is a bitmap (big.Int, bit N set means
+// block from+N is a candidate) that callers must still re-check against the
+// exact per-block bloom, since bloombits - like the per-block blooms
+// themselves - can only produce false positives, never false negatives.
+func (s *EvmAuxStore) MatchSections(from, to uint64, blooms []types.BloomFilter) (*big.Int, error) {
+	if to < from {
+		return big.NewInt(0), nil
+	}
+	bits := bloomBitPositions(blooms)
+	return matchSectionRange(from, to, bits, func(section uint64, bits []uint) (*big.Int, error) {
+		return s.sectionMatchBits(section, bits)
+	})
+}
+
+// matchSectionRange walks [from, to] one section at a time, ANDing in the
+// per-section match bits returned by sectionMatch into one contiguous
+// result bitmap (bit N set means block from+N is a candidate). Kept pure
+// (the per-section lookup is injected) so the section-spanning logic can be
+// unit tested against synthetic sections without a live store.
+func matchSectionRange(from, to uint64, bits []uint, sectionMatch func(section uint64, bits []uint) (*big.Int, error)) (*big.Int, error) {
+	result := new(big.Int)
+	resultBit := uint(0)
+
+	for height := from; height <= to; height += bloomSectionSize - height%bloomSectionSize {
+		section := height / bloomSectionSize
+		sectionStart := section * bloomSectionSize
+		offsetStart := height - sectionStart
+		offsetEnd := bloomSectionSize
+		if sectionStart+bloomSectionSize > to {
+			offsetEnd = to - sectionStart + 1
+		}
+
+		sectionBits, err := sectionMatch(section, bits)
+		if err != nil {
+			return nil, err
+		}
+		for offset := offsetStart; offset < offsetEnd; offset++ {
+			if sectionBits.Bit(int(offset)) == 1 {
+				result.SetBit(result, int(resultBit), 1)
+			}
+			resultBit++
+		}
+	}
+
+	return result, nil
+}
+
+// sectionMatchBits ANDs together the decompressed bit-vectors for the given
+// bit positions within one section. A section that hasn't been built yet
+// (e.g. it's still within the one-section builder lag) errors rather than
+// yielding all-zero bits: MatchSections promises callers it never produces
+// false negatives, and an all-zero "no match" for a section nobody has
+// scanned yet would be exactly that. Callers are expected to fall back to
+// scanning those blocks directly via the per-height blooms on this error.
+func (s *EvmAuxStore) sectionMatchBits(section uint64, bits []uint) (*big.Int, error) {
+	return sectionMatchBitsForSection(section, s.nextBloomBitsSection(), bits, func(bit uint) []byte {
+		return s.db.Get(bloomBitsKey(bit, section))
+	})
+}
+
+// sectionMatchBitsForSection is sectionMatchBits with the "next unbuilt
+// section" watermark and the store lookup both injected, so the
+// not-yet-built check can be unit tested against a synthetic watermark
+// without a live store.
+func sectionMatchBitsForSection(section, nextUnbuiltSection uint64, bits []uint, lookup func(bit uint) []byte) (*big.Int, error) {
+	if section >= nextUnbuiltSection {
+		return nil, errors.Wrapf(errIncompleteSection, "section %d not yet built", section)
+	}
+	return sectionMatchBitsPure(bits, lookup)
+}
+
+// sectionMatchBitsPure is sectionMatchBitsForSection with the "is this
+// section built" check already done, so it can be unit tested against
+// synthetic bit-vectors in isolation. A missing raw vector here means a
+// section the caller already believes is built is missing data - that's
+// corruption, not an ordinary hole, so it errors rather than returning a
+// silently wrong all-zero match.
+func sectionMatchBitsPure(bits []uint, lookup func(bit uint) []byte) (*big.Int, error) {
+	if len(bits) == 0 {
+		ones := new(big.Int)
+		for i := uint64(0); i < bloomSectionSize; i++ {
+			ones.SetBit(ones, int(i), 1)
+		}
+		return ones, nil
+	}
+
+	var result *big.Int
+	for _, bit := range bits {
+		raw := lookup(bit)
+		if len(raw) == 0 {
+			return nil, errors.Wrapf(errIncompleteSection, "missing bit-vector for bit %d", bit)
+		}
+		vector := decompressBitVector(raw, bloomSectionSize/8)
+		asInt := new(big.Int).SetBytes(reverseBytes(vector))
+		if result == nil {
+			result = asInt
+		} else {
+			result.And(result, asInt)
+		}
+	}
+	return result, nil
+}
+
+// bloomBitPositions returns the set of 2048-bit bloom positions that must
+// all be set for a log matching any of the given per-topic-group blooms to
+// be present in a block.
+func bloomBitPositions(blooms []types.BloomFilter) []uint {
+	seen := map[uint]bool{}
+	var bits []uint
+	for _, filter := range blooms {
+		for bit := uint(0); bit < bloomBitsPerFilter; bit++ {
+			byteIdx := bloomByteLength - 1 - int(bit)/8
+			bitIdx := bit % 8
+			if byteIdx < 0 || byteIdx >= len(filter) {
+				continue
+			}
+			if filter[byteIdx]&(1<<bitIdx) == 0 {
+				continue
+			}
+			if !seen[bit] {
+				seen[bit] = true
+				bits = append(bits, bit)
+			}
+		}
+	}
+	return bits
+}
+
+func reverseBytes(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i, v := range b {
+		out[len(b)-1-i] = v
+	}
+	return out
+}
+
+// compressBitVector run-length encodes a mostly-sparse bit-vector: each run
+// is stored as a byte count (up to 255) followed by the literal byte that
+// repeats that many times. This is the same trick bitutil-style compression
+// in go-ethereum uses to keep bloombits sections small on disk, since most
+// bits are 0 for most sections.
+func compressBitVector(vector []byte) []byte {
+	if len(vector) == 0 {
+		return nil
+	}
+	out := make([]byte, 0, len(vector)/4+2)
+	i := 0
+	for i < len(vector) {
+		run := vector[i]
+		count := 1
+		for i+count < len(vector) && vector[i+count] == run && count < 255 {
+			count++
+		}
+		out = append(out, byte(count), run)
+		i += count
+	}
+	return out
+}
+
+func decompressBitVector(compressed []byte, expectedLen int) []byte {
+	out := make([]byte, 0, expectedLen)
+	for i := 0; i+1 < len(compressed); i += 2 {
+		count := int(compressed[i])
+		run := compressed[i+1]
+		for j := 0; j < count; j++ {
+			out = append(out, run)
+		}
+	}
+	if len(out) < expectedLen {
+		padded := make([]byte, expectedLen)
+		copy(padded, out)
+		return padded
+	}
+	return out[:expectedLen]
+}
@@ -0,0 +1,171 @@
+package evmaux
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressDecompressBitVectorRoundTrip(t *testing.T) {
+	vector := make([]byte, bloomSectionSize/8)
+	vector[0] = 0xFF
+	vector[10] = 0x01
+	vector[len(vector)-1] = 0x80
+
+	compressed := compressBitVector(vector)
+	require.NotEmpty(t, compressed)
+
+	decompressed := decompressBitVector(compressed, len(vector))
+	require.Equal(t, vector, decompressed)
+}
+
+func TestBloomBitsKeyDistinctPerBitAndSection(t *testing.T) {
+	require.NotEqual(t, bloomBitsKey(0, 0), bloomBitsKey(1, 0))
+	require.NotEqual(t, bloomBitsKey(0, 0), bloomBitsKey(0, 1))
+}
+
+// fakeHeightBlooms backs buildSectionVectors with an in-memory per-height
+// bloom filter, the minimum needed to exercise CommitBloomSection's actual
+// rotation logic without a store.
+func fakeHeightBlooms(setBits map[uint64][]uint) func(height uint64) []byte {
+	return func(height uint64) []byte {
+		bits, ok := setBits[height]
+		filter := make([]byte, bloomByteLength)
+		if !ok {
+			return filter
+		}
+		for _, bit := range bits {
+			byteIdx := bloomByteLength - 1 - int(bit)/8
+			filter[byteIdx] |= 1 << (bit % 8)
+		}
+		return filter
+	}
+}
+
+func TestBuildSectionVectorsSetsBitForEachMatchingHeight(t *testing.T) {
+	from := uint64(0)
+	to := from + bloomSectionSize
+	lookup := fakeHeightBlooms(map[uint64][]uint{
+		from + 5:   {17},
+		from + 200: {17},
+	})
+
+	vectors, err := buildSectionVectors(from, to, lookup)
+	require.NoError(t, err)
+	require.Len(t, vectors, bloomBitsPerFilter)
+
+	require.Equal(t, byte(1<<(5%8)), vectors[17][5/8])
+	require.Equal(t, byte(1<<(200%8)), vectors[17][200/8])
+	// A bit never set at any height must stay all-zero.
+	require.Equal(t, make([]byte, bloomSectionSize/8), vectors[0])
+}
+
+func TestBuildSectionVectorsTreatsMissingBloomAsHole(t *testing.T) {
+	// A height with no recorded bloom (e.g. a zero-receipt block) must
+	// contribute no set bits rather than failing the whole section - the
+	// same hole-handling bloom_mipmap.go's RebuildBloomMipMap already does.
+	from := uint64(0)
+	to := from + bloomSectionSize
+	lookup := func(height uint64) []byte {
+		if height == from+3 {
+			return nil // a hole: this height's bloom was never recorded
+		}
+		return make([]byte, bloomByteLength)
+	}
+
+	vectors, err := buildSectionVectors(from, to, lookup)
+
+	require.NoError(t, err)
+	require.Len(t, vectors, bloomBitsPerFilter)
+}
+
+func TestSectionMatchBitsPureAndsAcrossBitPositions(t *testing.T) {
+	vectorA := make([]byte, bloomSectionSize/8)
+	vectorA[0] = 0xFF // blocks 0-7 set
+	vectorB := make([]byte, bloomSectionSize/8)
+	vectorB[0] = 0x0F // only blocks 0-3 set
+
+	lookup := func(bit uint) []byte {
+		switch bit {
+		case 1:
+			return compressBitVector(vectorA)
+		case 2:
+			return compressBitVector(vectorB)
+		}
+		return nil
+	}
+
+	result, err := sectionMatchBitsPure([]uint{1, 2}, lookup)
+	require.NoError(t, err)
+
+	for block := 0; block < 4; block++ {
+		require.Equal(t, uint(1), result.Bit(block), "block %d should match (both bits set)", block)
+	}
+	for block := 4; block < 8; block++ {
+		require.Equal(t, uint(0), result.Bit(block), "block %d should not match (only bit 1 set)", block)
+	}
+}
+
+func TestSectionMatchBitsPureErrorsOnMissingVector(t *testing.T) {
+	// sectionMatchBitsPure is only ever called for a section the caller
+	// already knows is built, so a missing raw vector means corruption, not
+	// an ordinary hole - it must error rather than returning a silently
+	// wrong all-zero "no match" (MatchSections promises no false negatives).
+	lookup := func(bit uint) []byte { return nil }
+
+	_, err := sectionMatchBitsPure([]uint{5}, lookup)
+
+	require.Error(t, err)
+}
+
+func TestSectionMatchBitsForSectionErrorsWhenNotYetBuilt(t *testing.T) {
+	// A section at or beyond the builder's watermark (e.g. still within the
+	// one-section lag) hasn't been written at all, so it must error rather
+	// than come back as a false-negative-risking all-zero match - callers
+	// are expected to fall back to scanning the raw per-height blooms
+	// directly on this error.
+	lookup := func(bit uint) []byte { t.Fatal("must not read bit-vectors for an unbuilt section"); return nil }
+
+	_, err := sectionMatchBitsForSection(5, 5, []uint{1}, lookup)
+
+	require.Error(t, err)
+}
+
+func TestSectionMatchBitsForSectionDelegatesWhenBuilt(t *testing.T) {
+	vector := make([]byte, bloomSectionSize/8)
+	vector[0] = 0xFF
+	lookup := func(bit uint) []byte { return compressBitVector(vector) }
+
+	result, err := sectionMatchBitsForSection(4, 5, []uint{1}, lookup)
+
+	require.NoError(t, err)
+	require.Equal(t, uint(1), result.Bit(0))
+}
+
+func TestMatchSectionRangeSpansMultipleSections(t *testing.T) {
+	// from/to crossing a section boundary must combine per-section match
+	// bits into one contiguous result bitmap, not just report the first
+	// section's matches.
+	calls := map[uint64][]uint{}
+	sectionMatch := func(section uint64, bits []uint) (*big.Int, error) {
+		calls[section] = bits
+		result := new(big.Int)
+		if section == 0 {
+			result.SetBit(result, int(bloomSectionSize-1), 1) // last block of section 0
+		}
+		if section == 1 {
+			result.SetBit(result, 0, 1) // first block of section 1
+		}
+		return result, nil
+	}
+
+	from := bloomSectionSize - 1
+	to := bloomSectionSize
+	result, err := matchSectionRange(from, to, []uint{9}, sectionMatch)
+	require.NoError(t, err)
+
+	require.Len(t, calls, 2, "must query both sections the range spans")
+	require.Equal(t, uint(1), result.Bit(0), "from (last block of section 0) must match")
+	require.Equal(t, uint(1), result.Bit(1), "to (first block of section 1) must match")
+}
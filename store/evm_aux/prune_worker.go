@@ -0,0 +1,345 @@
+package evmaux
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/loomnetwork/go-loom/plugin/types"
+	"github.com/loomnetwork/loomchain/log"
+	"github.com/pkg/errors"
+)
+
+// pruneBatchSize is how many list nodes the background worker deletes (and
+// commits) per batch, so a burst of pruning never holds the db write lock
+// for longer than it takes to process one batch.
+const pruneBatchSize = 1000
+
+// defaultCompactionThreshold is the cumulative number of pruned receipts
+// after which the worker triggers Compact() to reclaim the tombstones
+// pruning otherwise leaves behind indefinitely.
+const defaultCompactionThreshold = 100000
+
+// pruneJob describes a contiguous run of list nodes, starting at oldHead,
+// that CommitReceipts has already excluded from the live list (by advancing
+// the head pointer past them) but that still need their storage reclaimed.
+type pruneJob struct {
+	oldHead []byte
+	count   uint64
+}
+
+// PruneMetrics is a snapshot of the background pruning worker's state,
+// exposed so operators can alert on a growing backlog.
+type PruneMetrics struct {
+	Backlog             int64
+	Compactions         int64
+	LastCompactionNanos int64
+	ReceiptsDeleted     int64
+}
+
+type pruneWorker struct {
+	store *EvmAuxStore
+	jobs  chan pruneJob
+	quit  chan struct{}
+	wg    sync.WaitGroup
+
+	backlog             int64
+	compactions         int64
+	lastCompactionNanos int64
+	receiptsDeleted     int64
+	sinceCompaction     uint64
+	compactionThreshold uint64
+}
+
+func newPruneWorker(store *EvmAuxStore) *pruneWorker {
+	w := &pruneWorker{
+		store:               store,
+		jobs:                make(chan pruneJob, 256),
+		quit:                make(chan struct{}),
+		compactionThreshold: defaultCompactionThreshold,
+	}
+	w.wg.Add(1)
+	go w.run()
+	return w
+}
+
+func (w *pruneWorker) run() {
+	defer w.wg.Done()
+	for {
+		select {
+		case job := <-w.jobs:
+			atomic.AddInt64(&w.backlog, -1)
+			w.process(job)
+		case <-w.quit:
+			w.drain()
+			return
+		}
+	}
+}
+
+// drain processes whatever jobs are already queued before the worker exits,
+// so Close() doesn't leave pruning permanently unfinished.
+func (w *pruneWorker) drain() {
+	for {
+		select {
+		case job := <-w.jobs:
+			atomic.AddInt64(&w.backlog, -1)
+			w.process(job)
+		default:
+			return
+		}
+	}
+}
+
+func (w *pruneWorker) enqueue(job pruneJob) {
+	if job.count == 0 {
+		return
+	}
+	atomic.AddInt64(&w.backlog, 1)
+	w.jobs <- job
+}
+
+// process deletes the nodes described by job in batches of pruneBatchSize,
+// committing each batch independently, and compacts once the cumulative
+// deletions since the last compaction reach compactionThreshold. If the
+// process stops partway through a job, the batches already committed stay
+// deleted; the job's remaining nodes are left in the db, unreachable from
+// the (already advanced) head, and nothing deletes them later.
+func (w *pruneWorker) process(job pruneJob) {
+	head := job.oldHead
+	remaining := job.count
+
+	for remaining > 0 {
+		batch := pruneBatchSize
+		if uint64(batch) > remaining {
+			batch = int(remaining)
+		}
+		next, deleted, err := w.deleteBatch(head, uint64(batch))
+		if err != nil {
+			log.Error("evmaux: prune worker failed to delete batch", "err", err)
+			return
+		}
+		head = next
+		remaining -= deleted
+		atomic.AddInt64(&w.receiptsDeleted, int64(deleted))
+		atomic.AddUint64(&w.sinceCompaction, deleted)
+
+		if atomic.LoadUint64(&w.sinceCompaction) >= w.compactionThreshold {
+			w.compact()
+		}
+	}
+}
+
+func (w *pruneWorker) deleteBatch(head []byte, count uint64) ([]byte, uint64, error) {
+	s := w.store
+	mu := s.lockCommit()
+	mu.Lock()
+	defer mu.Unlock()
+	s.Rollback()
+	var deleted uint64
+	var deletedHashes [][]byte
+	for deleted < count && len(head) > 0 {
+		itemProto := s.db.Get(head)
+		if len(itemProto) == 0 {
+			break
+		}
+		item := types.EvmTxReceiptListItem{}
+		if err := proto.Unmarshal(itemProto, &item); err != nil {
+			return head, deleted, errors.Wrapf(err, "unmarshal head %x", head)
+		}
+		s.batch.Delete(head)
+		deletedHashes = append(deletedHashes, head)
+		deleted++
+		head = item.NextTxHash
+	}
+	// Deindex the whole batch at once rather than one tx hash at a time, so
+	// multiple removals from the same block accumulate instead of each
+	// overwriting the last with a stale read of the not-yet-committed list.
+	s.deindexTxHashes(deletedHashes)
+	s.Commit()
+	return head, deleted, nil
+}
+
+func (w *pruneWorker) compact() {
+	atomic.StoreUint64(&w.sinceCompaction, 0)
+	start := time.Now()
+	compactor, ok := w.store.db.(interface{ Compact() error })
+	if !ok {
+		return
+	}
+	if err := compactor.Compact(); err != nil {
+		log.Error("evmaux: compaction failed", "err", err)
+		return
+	}
+	atomic.AddInt64(&w.compactions, 1)
+	atomic.StoreInt64(&w.lastCompactionNanos, time.Since(start).Nanoseconds())
+}
+
+func (w *pruneWorker) metrics() PruneMetrics {
+	return PruneMetrics{
+		Backlog:             atomic.LoadInt64(&w.backlog),
+		Compactions:         atomic.LoadInt64(&w.compactions),
+		LastCompactionNanos: atomic.LoadInt64(&w.lastCompactionNanos),
+		ReceiptsDeleted:     atomic.LoadInt64(&w.receiptsDeleted),
+	}
+}
+
+func (w *pruneWorker) stop() {
+	close(w.quit)
+	w.wg.Wait()
+}
+
+// pruneWorkers tracks the one background pruneWorker per EvmAuxStore
+// instance, following the same lazy-per-store-instance pattern
+// notifyBloomSectionHeight uses for the bloombits builder.
+var (
+	pruneWorkersMu sync.Mutex
+	pruneWorkers   = map[*EvmAuxStore]*pruneWorker{}
+)
+
+// commitMus guards each EvmAuxStore's Rollback/batch-mutate/Commit cycle.
+// s.batch is shared, unsynchronized per-store state, and CommitReceipts, the
+// background pruneWorker, and the bloombits/MIPmap builders can all stage a
+// Rollback+batch+Commit cycle against the same store concurrently - without
+// this, one goroutine's Rollback() can discard another's in-flight batch
+// mid-build. Keyed by store pointer rather than a field on EvmAuxStore
+// itself, the same way pruneWorkers/sectionBuilders are, since callers
+// construct EvmAuxStore with no knowledge of these background features.
+var (
+	commitMusMu sync.Mutex
+	commitMus   = map[*EvmAuxStore]*sync.Mutex{}
+)
+
+// lockCommit returns the mutex serializing Rollback/batch-mutate/Commit
+// cycles for this store. Every method that calls s.Rollback() followed
+// eventually by s.Commit() must hold this for the whole sequence.
+func (s *EvmAuxStore) lockCommit() *sync.Mutex {
+	commitMusMu.Lock()
+	defer commitMusMu.Unlock()
+	mu, ok := commitMus[s]
+	if !ok {
+		mu = &sync.Mutex{}
+		commitMus[s] = mu
+	}
+	return mu
+}
+
+func (s *EvmAuxStore) pruneWorkerFor() *pruneWorker {
+	pruneWorkersMu.Lock()
+	defer pruneWorkersMu.Unlock()
+	w, ok := pruneWorkers[s]
+	if !ok {
+		w = newPruneWorker(s)
+		pruneWorkers[s] = w
+	}
+	return w
+}
+
+// enqueuePruneJob hands off a run of already-unlinked receipts to the
+// background worker. Called from CommitReceipts once it has advanced the
+// head pointer past them.
+func (s *EvmAuxStore) enqueuePruneJob(job pruneJob) {
+	s.pruneWorkerFor().enqueue(job)
+}
+
+// PruneMetrics returns a snapshot of the background pruning worker's state
+// for this store (zero value if pruning has never run).
+func (s *EvmAuxStore) PruneMetrics() PruneMetrics {
+	pruneWorkersMu.Lock()
+	w, ok := pruneWorkers[s]
+	pruneWorkersMu.Unlock()
+	if !ok {
+		return PruneMetrics{}
+	}
+	return w.metrics()
+}
+
+// Close flushes any pending prune jobs and stops the background pruning and
+// bloombits section-building workers started for this store. It's safe to
+// call even if neither worker was ever started.
+func (s *EvmAuxStore) Close() error {
+	pruneWorkersMu.Lock()
+	w, ok := pruneWorkers[s]
+	if ok {
+		delete(pruneWorkers, s)
+	}
+	pruneWorkersMu.Unlock()
+	if ok {
+		w.stop()
+	}
+	s.StopBloomSectionBuilder()
+
+	commitMusMu.Lock()
+	delete(commitMus, s)
+	commitMusMu.Unlock()
+	return nil
+}
+
+// advanceHead walks count nodes forward from head without deleting them,
+// returning the hash that should become the new head. CommitReceipts uses
+// this to advance the head pointer immediately while deferring the actual
+// deletes to the background pruneWorker, since the pointer advance and the
+// deletes are no longer a single atomic step.
+func (s *EvmAuxStore) advanceHead(head []byte, count uint64) ([]byte, error) {
+	for i := uint64(0); i < count; i++ {
+		if len(head) == 0 {
+			return head, errors.Errorf("unable to advance head by %d, only %d entries available", count, i)
+		}
+		itemProto := s.db.Get(head)
+		if len(itemProto) == 0 {
+			return head, errors.Errorf("unable to advance head by %d, only %d entries available", count, i)
+		}
+		item := types.EvmTxReceiptListItem{}
+		if err := proto.Unmarshal(itemProto, &item); err != nil {
+			return head, errors.Wrapf(err, "unmarshal head %x", head)
+		}
+		head = item.NextTxHash
+	}
+	return head, nil
+}
+
+// ReconcileHeadAndSize walks the list from the stored head to the stored
+// tail and, if the node count disagrees with the stored size, rewrites size
+// to match reality. It's meant to run once at startup. Nodes that
+// CommitReceipts already unlinked by advancing the head but the pruneWorker
+// had not yet deleted before a crash are not reachable from the head; they
+// are neither counted nor deleted here.
+func (s *EvmAuxStore) ReconcileHeadAndSize() error {
+	mu := s.lockCommit()
+	mu.Lock()
+	defer mu.Unlock()
+	s.Rollback()
+	size, head, tail, err := s.getDBParams()
+	if err != nil {
+		return errors.Wrap(err, "getting db params")
+	}
+	if len(head) == 0 {
+		return nil
+	}
+
+	var actual uint64
+	cur := head
+	for len(cur) > 0 {
+		itemProto := s.db.Get(cur)
+		if len(itemProto) == 0 {
+			break
+		}
+		actual++
+		item := types.EvmTxReceiptListItem{}
+		if err := proto.Unmarshal(itemProto, &item); err != nil {
+			return errors.Wrapf(err, "unmarshal node %x during reconcile", cur)
+		}
+		if len(item.NextTxHash) == 0 {
+			break
+		}
+		cur = item.NextTxHash
+	}
+
+	if actual != size {
+		log.Info("evmaux: reconciling receipt list size after unclean shutdown", "stored", size, "actual", actual)
+		s.setDBParams(actual, head, tail)
+		s.Commit()
+	}
+	return nil
+}
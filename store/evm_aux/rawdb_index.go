@@ -0,0 +1,332 @@
+package evmaux
+
+import (
+	"encoding/binary"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/loomnetwork/go-loom/plugin/types"
+	"github.com/loomnetwork/loomchain/log"
+	"github.com/pkg/errors"
+)
+
+// This file adds a rawdb-style secondary index on top of the receipt
+// linked list kept by receipts.go, so RPC handlers can answer
+// "receipts for block N/blockHash" and "which block is tx X in" with point
+// lookups instead of walking NextTxHash or scanning getTxHashList.
+
+const (
+	rawdbBlockNumPrefix  = "rawdb/blocknum/"
+	rawdbBlockHashPrefix = "rawdb/blockhash/"
+	rawdbTxLookupPrefix  = "rawdb/txlookup/"
+)
+
+func rawdbBlockNumKey(blockNum uint64) []byte {
+	key := make([]byte, 0, len(rawdbBlockNumPrefix)+8)
+	key = append(key, rawdbBlockNumPrefix...)
+	numB := make([]byte, 8)
+	binary.BigEndian.PutUint64(numB, blockNum)
+	return append(key, numB...)
+}
+
+func rawdbBlockHashKey(blockHash []byte) []byte {
+	return append([]byte(rawdbBlockHashPrefix), blockHash...)
+}
+
+func rawdbTxLookupKey(txHash []byte) []byte {
+	return append([]byte(rawdbTxLookupPrefix), txHash...)
+}
+
+// TxLookupEntry records where a transaction's receipt lives, so
+// eth_getTransactionByHash style RPCs don't have to walk the receipt list.
+type TxLookupEntry struct {
+	BlockHash   []byte
+	BlockNumber uint64
+	TxIndex     uint32
+}
+
+// encodeTxHashList/decodeTxHashList store a repeated []byte field as a
+// simple length-prefixed blob, the same shape the rest of evmaux already
+// uses for small ad-hoc lists (see setTxHashList) - there's no dedicated
+// proto message for "list of hashes" so we avoid introducing one just for
+// this index.
+func encodeTxHashList(hashes [][]byte) []byte {
+	out := make([]byte, 0)
+	for _, h := range hashes {
+		lenB := make([]byte, 4)
+		binary.BigEndian.PutUint32(lenB, uint32(len(h)))
+		out = append(out, lenB...)
+		out = append(out, h...)
+	}
+	return out
+}
+
+func decodeTxHashList(data []byte) [][]byte {
+	var hashes [][]byte
+	for len(data) >= 4 {
+		n := binary.BigEndian.Uint32(data[:4])
+		data = data[4:]
+		if uint32(len(data)) < n {
+			break
+		}
+		hashes = append(hashes, data[:n])
+		data = data[n:]
+	}
+	return hashes
+}
+
+func encodeTxLookupEntry(e TxLookupEntry) []byte {
+	out := make([]byte, 0, len(e.BlockHash)+12)
+	numB := make([]byte, 8)
+	binary.BigEndian.PutUint64(numB, e.BlockNumber)
+	idxB := make([]byte, 4)
+	binary.BigEndian.PutUint32(idxB, e.TxIndex)
+	out = append(out, numB...)
+	out = append(out, idxB...)
+	out = append(out, e.BlockHash...)
+	return out
+}
+
+func decodeTxLookupEntry(data []byte) (TxLookupEntry, error) {
+	if len(data) < 12 {
+		return TxLookupEntry{}, errors.New("corrupt tx lookup entry")
+	}
+	return TxLookupEntry{
+		BlockNumber: binary.BigEndian.Uint64(data[:8]),
+		TxIndex:     binary.BigEndian.Uint32(data[8:12]),
+		BlockHash:   append([]byte(nil), data[12:]...),
+	}, nil
+}
+
+// indexReceiptsForBlock populates the blockNum->txHashes, blockHash->
+// blockNum, and txHash->lookup indices for one block's worth of receipts.
+// It's called from CommitReceipts in the same batch as the linked-list
+// update, so the indices can never diverge from the list short of a crash
+// mid-batch (handled by RebuildRawdbIndex at startup).
+func (s *EvmAuxStore) indexReceiptsForBlock(receipts []*types.EvmTxReceipt, height uint64) {
+	if len(receipts) == 0 {
+		return
+	}
+
+	var blockHash []byte
+	var txHashes [][]byte
+	var txIndex uint32
+	for _, r := range receipts {
+		if r == nil || len(r.TxHash) == 0 {
+			continue
+		}
+		if len(blockHash) == 0 {
+			blockHash = r.BlockHash
+		}
+		txHashes = append(txHashes, r.TxHash)
+		s.batch.Set(rawdbTxLookupKey(r.TxHash), encodeTxLookupEntry(TxLookupEntry{
+			BlockHash:   blockHash,
+			BlockNumber: height,
+			TxIndex:     txIndex,
+		}))
+		txIndex++
+	}
+	if len(txHashes) == 0 {
+		return
+	}
+	s.batch.Set(rawdbBlockNumKey(height), encodeTxHashList(txHashes))
+	if len(blockHash) > 0 {
+		numB := make([]byte, 8)
+		binary.BigEndian.PutUint64(numB, height)
+		s.batch.Set(rawdbBlockHashKey(blockHash), numB)
+	}
+}
+
+// blockRemoval accumulates every tx hash being deindexed from the same
+// block within one deindexTxHashes call, plus the block hash needed to drop
+// its rawdb/blockhash entry once the list empties out.
+type blockRemoval struct {
+	blockHash []byte
+	removed   [][]byte
+}
+
+// applyBlockRemovals takes the tx-hash removals accumulated per block by
+// deindexTxHashes and, for each block, reads its current list exactly once
+// via lookup and returns the post-removal list. Kept pure (no s.db/s.batch)
+// so it can be unit tested directly against synthetic block lists.
+func applyBlockRemovals(byBlock map[uint64]*blockRemoval, lookup func(blockNum uint64) [][]byte) map[uint64][][]byte {
+	result := make(map[uint64][][]byte, len(byBlock))
+	for blockNum, br := range byBlock {
+		remaining := lookup(blockNum)
+		for _, txHash := range br.removed {
+			remaining = removeHashFromList(remaining, txHash)
+		}
+		result[blockNum] = remaining
+	}
+	return result
+}
+
+// deindexTxHashes removes the rawdb lookup entries for a batch of tx hashes
+// pruned together, and drops each one from its block's tx list. Called from
+// the prune worker's deleteBatch, once per delete batch rather than once per
+// tx hash: s.db.Get only ever sees already-committed state, not this same
+// batch's earlier Set calls, so a read-modify-write of a block's tx list per
+// tx hash would let a second removal from the same block clobber the first
+// with a stale list. Accumulating all of a block's removals first and
+// writing its list once avoids that.
+func (s *EvmAuxStore) deindexTxHashes(txHashes [][]byte) {
+	if len(txHashes) == 0 {
+		return
+	}
+
+	byBlock := map[uint64]*blockRemoval{}
+	for _, txHash := range txHashes {
+		raw := s.db.Get(rawdbTxLookupKey(txHash))
+		if len(raw) == 0 {
+			continue
+		}
+		entry, err := decodeTxLookupEntry(raw)
+		if err != nil {
+			log.Error("evmaux: failed to decode tx lookup entry during prune", "err", err)
+			s.batch.Delete(rawdbTxLookupKey(txHash))
+			continue
+		}
+		s.batch.Delete(rawdbTxLookupKey(txHash))
+
+		br, ok := byBlock[entry.BlockNumber]
+		if !ok {
+			br = &blockRemoval{blockHash: entry.BlockHash}
+			byBlock[entry.BlockNumber] = br
+		}
+		br.removed = append(br.removed, txHash)
+	}
+
+	remaining := applyBlockRemovals(byBlock, func(blockNum uint64) [][]byte {
+		return decodeTxHashList(s.db.Get(rawdbBlockNumKey(blockNum)))
+	})
+
+	for blockNum, hashes := range remaining {
+		blockNumKey := rawdbBlockNumKey(blockNum)
+		if len(hashes) == 0 {
+			s.batch.Delete(blockNumKey)
+			if blockHash := byBlock[blockNum].blockHash; len(blockHash) > 0 {
+				s.batch.Delete(rawdbBlockHashKey(blockHash))
+			}
+			continue
+		}
+		s.batch.Set(blockNumKey, encodeTxHashList(hashes))
+	}
+}
+
+func removeHashFromList(hashes [][]byte, target []byte) [][]byte {
+	out := hashes[:0]
+	for _, h := range hashes {
+		if string(h) != string(target) {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// GetReceiptsByBlockNumber returns every receipt committed for a block,
+// without walking the NextTxHash linked list or scanning getTxHashList.
+func (s *EvmAuxStore) GetReceiptsByBlockNumber(blockNum uint64) ([]types.EvmTxReceipt, error) {
+	raw := s.db.Get(rawdbBlockNumKey(blockNum))
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	hashes := decodeTxHashList(raw)
+	receipts := make([]types.EvmTxReceipt, 0, len(hashes))
+	for _, txHash := range hashes {
+		receipt, err := s.GetReceipt(txHash)
+		if err != nil {
+			return nil, errors.Wrapf(err, "loading receipt for tx %x at block %d", txHash, blockNum)
+		}
+		receipts = append(receipts, receipt)
+	}
+	return receipts, nil
+}
+
+// GetReceiptsByBlockHash resolves a block hash to its block number via the
+// rawdb index, then delegates to GetReceiptsByBlockNumber.
+func (s *EvmAuxStore) GetReceiptsByBlockHash(blockHash []byte) ([]types.EvmTxReceipt, error) {
+	raw := s.db.Get(rawdbBlockHashKey(blockHash))
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	blockNum := binary.BigEndian.Uint64(raw)
+	return s.GetReceiptsByBlockNumber(blockNum)
+}
+
+// GetTransactionLookup returns the block hash, block number and index
+// within the block of the transaction with the given hash, so RPC handlers
+// can build an eth_getTransactionByHash response without walking the
+// linked list.
+func (s *EvmAuxStore) GetTransactionLookup(txHash []byte) (blockHash []byte, blockNum uint64, txIndex uint32, err error) {
+	raw := s.db.Get(rawdbTxLookupKey(txHash))
+	if len(raw) == 0 {
+		return nil, 0, 0, ErrTxReceiptNotFound
+	}
+	entry, err := decodeTxLookupEntry(raw)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return entry.BlockHash, entry.BlockNumber, entry.TxIndex, nil
+}
+
+// RebuildRawdbIndex reconstructs the blockNum/blockHash/tx-lookup indices by
+// walking the existing head->tail receipt chain once. It's meant to run at
+// startup on installations upgrading from a version that only kept the
+// linked list, so RPC handlers can rely on the new indices without a
+// resync.
+func (s *EvmAuxStore) RebuildRawdbIndex() error {
+	mu := s.lockCommit()
+	mu.Lock()
+	defer mu.Unlock()
+
+	s.Rollback()
+	_, head, _, err := s.getDBParams()
+	if err != nil {
+		return errors.Wrap(err, "getting db params")
+	}
+
+	// The linked list alone doesn't record block height/hash per node for
+	// older entries, so we reindex using whatever the receipt itself
+	// already carries (BlockHash/BlockNumber). CommitReceipts stores each
+	// receipt exactly as passed in, so these are the values its callers
+	// set.
+	next := head
+	grouped := map[uint64][][]byte{}
+	blockHashes := map[uint64][]byte{}
+	for len(next) > 0 {
+		itemProto := s.db.Get(next)
+		if len(itemProto) == 0 {
+			break
+		}
+		item := types.EvmTxReceiptListItem{}
+		if err := proto.Unmarshal(itemProto, &item); err != nil {
+			return errors.Wrap(err, "unmarshalling receipt list item during rawdb reindex")
+		}
+		if item.Receipt != nil {
+			h := item.Receipt.BlockNumber
+			grouped[h] = append(grouped[h], item.Receipt.TxHash)
+			if len(item.Receipt.BlockHash) > 0 {
+				blockHashes[h] = item.Receipt.BlockHash
+			}
+		}
+		next = item.NextTxHash
+	}
+
+	for height, hashes := range grouped {
+		for i, txHash := range hashes {
+			s.batch.Set(rawdbTxLookupKey(txHash), encodeTxLookupEntry(TxLookupEntry{
+				BlockHash:   blockHashes[height],
+				BlockNumber: height,
+				TxIndex:     uint32(i),
+			}))
+		}
+		s.batch.Set(rawdbBlockNumKey(height), encodeTxHashList(hashes))
+		if bh, ok := blockHashes[height]; ok {
+			numB := make([]byte, 8)
+			binary.BigEndian.PutUint64(numB, height)
+			s.batch.Set(rawdbBlockHashKey(bh), numB)
+		}
+	}
+
+	s.Commit()
+	return nil
+}
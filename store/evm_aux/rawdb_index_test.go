@@ -0,0 +1,80 @@
+package evmaux
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxHashListEncodeDecodeRoundTrip(t *testing.T) {
+	hashes := [][]byte{[]byte("hash-one"), []byte("hash-two"), []byte("hash-three")}
+	decoded := decodeTxHashList(encodeTxHashList(hashes))
+	require.Equal(t, hashes, decoded)
+}
+
+func TestTxLookupEntryEncodeDecodeRoundTrip(t *testing.T) {
+	entry := TxLookupEntry{
+		BlockHash:   []byte("a-block-hash"),
+		BlockNumber: 42,
+		TxIndex:     3,
+	}
+	decoded, err := decodeTxLookupEntry(encodeTxLookupEntry(entry))
+	require.NoError(t, err)
+	require.Equal(t, entry, decoded)
+}
+
+func TestRemoveHashFromList(t *testing.T) {
+	hashes := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	remaining := removeHashFromList(hashes, []byte("b"))
+	require.Equal(t, [][]byte{[]byte("a"), []byte("c")}, remaining)
+}
+
+func TestApplyBlockRemovalsAccumulatesWithinABlock(t *testing.T) {
+	// Two tx hashes pruned together from the same block must both be
+	// removed from that block's list in one shot: looking the list up once
+	// per block (not once per tx hash) is what prevents the second removal
+	// from being computed against a stale pre-removal read.
+	byBlock := map[uint64]*blockRemoval{
+		42: {blockHash: []byte("block-42-hash"), removed: [][]byte{[]byte("tx-a"), []byte("tx-c")}},
+	}
+	lookup := func(blockNum uint64) [][]byte {
+		require.Equal(t, uint64(42), blockNum)
+		return [][]byte{[]byte("tx-a"), []byte("tx-b"), []byte("tx-c")}
+	}
+
+	result := applyBlockRemovals(byBlock, lookup)
+
+	require.Equal(t, [][]byte{[]byte("tx-b")}, result[42])
+}
+
+func TestApplyBlockRemovalsEmptiesListWhenAllTxsPruned(t *testing.T) {
+	byBlock := map[uint64]*blockRemoval{
+		7: {removed: [][]byte{[]byte("only-tx")}},
+	}
+	lookup := func(blockNum uint64) [][]byte {
+		return [][]byte{[]byte("only-tx")}
+	}
+
+	result := applyBlockRemovals(byBlock, lookup)
+
+	require.Empty(t, result[7])
+}
+
+func TestApplyBlockRemovalsKeepsBlocksIndependent(t *testing.T) {
+	byBlock := map[uint64]*blockRemoval{
+		1: {removed: [][]byte{[]byte("a")}},
+		2: {removed: [][]byte{[]byte("x")}},
+	}
+	lists := map[uint64][][]byte{
+		1: {[]byte("a"), []byte("b")},
+		2: {[]byte("x"), []byte("y")},
+	}
+	lookup := func(blockNum uint64) [][]byte {
+		return lists[blockNum]
+	}
+
+	result := applyBlockRemovals(byBlock, lookup)
+
+	require.Equal(t, [][]byte{[]byte("b")}, result[1])
+	require.Equal(t, [][]byte{[]byte("y")}, result[2])
+}
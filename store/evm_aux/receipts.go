@@ -38,6 +38,10 @@ func (s *EvmAuxStore) CommitReceipts(receipts []*types.EvmTxReceipt, height uint
 		return nil
 	}
 
+	mu := s.lockCommit()
+	mu.Lock()
+	defer mu.Unlock()
+
 	s.Rollback()
 	size, headHash, tailHash, err := s.getDBParams()
 	if err != nil {
@@ -103,17 +107,20 @@ func (s *EvmAuxStore) CommitReceipts(receipts []*types.EvmTxReceipt, height uint
 		}
 	}
 
-	// clear old receipts if the number of receipts exceeds the limit
+	// Clear old receipts if the number of receipts exceeds the limit. The
+	// actual deletes happen off the critical path: here we only advance the
+	// head pointer past the entries being dropped and hand them off to the
+	// background pruneWorker, which deletes them in batches and compacts
+	// once enough tombstones have accumulated.
 	if s.maxReceipts < size {
-		var numDeleted uint64
-		headHash, numDeleted, err = s.removeOldEntries(headHash, size-s.maxReceipts)
+		pruneCount := size - s.maxReceipts
+		newHead, err := s.advanceHead(headHash, pruneCount)
 		if err != nil {
-			return errors.Wrap(err, "removing old receipts")
-		}
-		if size < numDeleted {
-			return errors.Wrap(err, "invalid count of deleted receipts")
+			return errors.Wrap(err, "advancing head for prune")
 		}
-		size -= numDeleted
+		s.enqueuePruneJob(pruneJob{oldHead: headHash, count: pruneCount})
+		headHash = newHead
+		size -= pruneCount
 	}
 	s.setDBParams(size, headHash, tailHash)
 
@@ -122,8 +129,11 @@ func (s *EvmAuxStore) CommitReceipts(receipts []*types.EvmTxReceipt, height uint
 		return errors.Wrap(err, "append tx list")
 	}
 	s.setBloomFilter(filter, height)
+	s.updateBloomMipMap(filter, height)
+	s.indexReceiptsForBlock(receipts, height)
 
 	s.Commit()
+	s.notifyBloomSectionHeight(height)
 	return nil
 }
 
@@ -159,22 +169,3 @@ func (s *EvmAuxStore) setDBParams(size uint64, head, tail []byte) {
 	binary.LittleEndian.PutUint64(sizeB, size)
 	s.batch.Set(currentDbSizeKey, sizeB)
 }
-
-func (s *EvmAuxStore) removeOldEntries(head []byte, number uint64) ([]byte, uint64, error) {
-	itemsDeleted := uint64(0)
-	for i := uint64(0); i < number && len(head) > 0; i++ {
-		headItem := s.db.Get(head)
-		txHeadReceiptItem := types.EvmTxReceiptListItem{}
-		if err := proto.Unmarshal(headItem, &txHeadReceiptItem); err != nil {
-			return head, itemsDeleted, errors.Wrapf(err, "unmarshal head %s", string(headItem))
-		}
-		s.batch.Delete(head)
-		itemsDeleted++
-		head = txHeadReceiptItem.NextTxHash
-	}
-	if itemsDeleted < number {
-		return head, itemsDeleted, errors.Errorf("Unable to delete %v receipts, only %v deleted", number, itemsDeleted)
-	}
-
-	return head, itemsDeleted, nil
-}